@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const metadataURL = "http://169.254.169.254/hetzner/v1/metadata"
+
+const (
+	// FetchTimeout bounds a single attempt, so one slow/hung request
+	// doesn't eat into the attempts that follow.
+	FetchTimeout = 3 * time.Second
+	// FetchInitialBackoff is the delay before the second attempt; it
+	// doubles after each subsequent failure up to FetchMaxBackoff.
+	FetchInitialBackoff = 500 * time.Millisecond
+	// FetchMaxBackoff caps the delay between individual attempts.
+	FetchMaxBackoff = 4 * time.Second
+	// FetchMaxElapsedEnv, if set to a value accepted by time.ParseDuration,
+	// overrides the default total wall-clock budget for retries.
+	FetchMaxElapsedEnv = "FETCH_MAX_ELAPSED"
+	// FetchMaxElapsed is the default total wall-clock budget for retries.
+	FetchMaxElapsed = 30 * time.Second
+)
+
+// httpStatusError is a non-2xx response from the metadata service.
+type httpStatusError struct{ code int }
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected http status %d", e.code)
+}
+
+// isRetryable reports whether err is worth trying again: connection
+// refused, timeouts, 5xx responses, and YAML parse errors are all typical
+// of a metadata service that hasn't fully come up yet. A 4xx response is
+// treated as permanent, since retrying it is never going to succeed.
+func isRetryable(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500
+	}
+	return true
+}
+
+// fetchMaxElapsed returns the total wall-clock budget for fetchMetadata's
+// retries, from FetchMaxElapsedEnv if set, otherwise FetchMaxElapsed.
+func fetchMaxElapsed() time.Duration {
+	if v := envOrDefault(FetchMaxElapsedEnv, ""); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		log.Printf("invalid %s %q, using default", FetchMaxElapsedEnv, v)
+	}
+	return FetchMaxElapsed
+}
+
+// fetchOnce issues a single, short-timeout request for the metadata
+// document and parses it.
+func fetchOnce(ctx context.Context) (*Metadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, &httpStatusError{code: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read error: %w", err)
+	}
+
+	var metadata Metadata
+	if err := yaml.Unmarshal(body, &metadata); err != nil {
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+
+	return &metadata, nil
+}
+
+// fetchMetadata fetches and parses the Hetzner Cloud metadata document,
+// retrying transient failures with exponential backoff (FetchInitialBackoff
+// doubling up to FetchMaxBackoff) until fetchMaxElapsed has passed. Between
+// attempts, recheckLink is called to make sure the temporary link-local
+// address set up around the virtual metadata interface hasn't been torn
+// down before the retry has a chance to use it.
+func fetchMetadata(ctx context.Context, recheckLink func()) (*Metadata, error) {
+	deadline := time.Now().Add(fetchMaxElapsed())
+	backoff := FetchInitialBackoff
+
+	var lastErr error
+	attempt := 1
+	for ; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, FetchTimeout)
+		metadata, err := fetchOnce(attemptCtx)
+		cancel()
+		if err == nil {
+			return metadata, nil
+		}
+
+		lastErr = err
+		if !isRetryable(err) {
+			return nil, fmt.Errorf("fetching metadata: %w", err)
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			break
+		}
+
+		log.Printf("fetching metadata (attempt %d): %v; retrying in %s", attempt, err, backoff)
+		if recheckLink != nil {
+			recheckLink()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > FetchMaxBackoff {
+			backoff = FetchMaxBackoff
+		}
+	}
+
+	return nil, fmt.Errorf("fetching metadata: giving up after %d attempts: %w", attempt, lastErr)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection error", errors.New("connection refused"), true},
+		{"parse error", errors.New("parse error: yaml: line 1"), true},
+		{"5xx status", &httpStatusError{code: 503}, true},
+		{"4xx status", &httpStatusError{code: 404}, false},
+		{"wrapped 4xx status", errors.Join(errors.New("context"), &httpStatusError{code: 400}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchMaxElapsed(t *testing.T) {
+	t.Cleanup(func() { os.Unsetenv(FetchMaxElapsedEnv) })
+
+	os.Unsetenv(FetchMaxElapsedEnv)
+	if got := fetchMaxElapsed(); got != FetchMaxElapsed {
+		t.Errorf("fetchMaxElapsed() = %v, want default %v", got, FetchMaxElapsed)
+	}
+
+	os.Setenv(FetchMaxElapsedEnv, "10s")
+	if got := fetchMaxElapsed(); got != 10*time.Second {
+		t.Errorf("fetchMaxElapsed() = %v, want %v", got, 10*time.Second)
+	}
+
+	os.Setenv(FetchMaxElapsedEnv, "not-a-duration")
+	if got := fetchMaxElapsed(); got != FetchMaxElapsed {
+		t.Errorf("fetchMaxElapsed() with invalid env = %v, want default %v", got, FetchMaxElapsed)
+	}
+}
@@ -1,17 +1,15 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net"
-	"net/http"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/vishvananda/netlink"
-	"gopkg.in/yaml.v3"
 )
 
 // Metadata is the YAML root structure of Hetzner Cloud metadata.
@@ -29,6 +27,14 @@ type NetworkConfigEntry struct {
 	Type       string
 	MACAddress string `yaml:"mac_address"`
 	Subnets    []Subnet
+	// Sysctls carries short-form sysctl knobs, e.g. "ipv6.conf.accept_ra=2",
+	// scoped to this interface. See applySysctls and writeSysctlDropin.
+	Sysctls []string `yaml:"sysctls"`
+	// Address and Search are only set when Type == "nameserver", a
+	// top-level cloud-init network-config v1 entry that isn't tied to any
+	// particular interface.
+	Address []string `yaml:"address"`
+	Search  []string `yaml:"search"`
 }
 
 type Subnet struct {
@@ -38,6 +44,29 @@ type Subnet struct {
 	Address        string
 	Gateway        string
 	DNSNameservers []string `yaml:"dns_nameservers"`
+	// AcceptRA, UseDNSFromRA and UseDomainsFromRA are optional hints that,
+	// when set, are rendered as IPv6AcceptRA=/UseDNS=/UseDomains=. AcceptRA
+	// overrides the IPv6AcceptRA=yes default this generator picks for an
+	// interface with an IPv6 subnet; UseDNS=/UseDomains= are only written
+	// when their hint is set.
+	AcceptRA         *bool `yaml:"accept_ra"`
+	UseDNSFromRA     *bool `yaml:"use_dns_from_ra"`
+	UseDomainsFromRA *bool `yaml:"use_domains_from_ra"`
+	// Routes are additional static routes, as seen on Hetzner Cloud servers
+	// attached to a Hetzner Network (vSwitch), rendered as [Route] sections.
+	Routes []Route
+	// MTU overrides the link's MTU, rendered as [Link] MTUBytes=.
+	MTU int `yaml:"mtu"`
+}
+
+// Route is a single static route attached to a Subnet, rendered as a
+// systemd-networkd [Route] section.
+type Route struct {
+	Destination string `yaml:"destination"`
+	Gateway     string `yaml:"gateway"`
+	Metric      int    `yaml:"metric"`
+	Table       string `yaml:"table"`
+	Scope       string `yaml:"scope"`
 }
 
 const (
@@ -49,9 +78,15 @@ const (
 	ConfigPrefix = "80-hetzner-"
 	// ConfigSuffix is the extension of systemd-networkd config files.
 	ConfigSuffix = ".network"
+
+	// ResolveDir is where the resolved.conf.d drop-in for top-level
+	// "nameserver" entries is written.
+	ResolveDir = "/run/systemd/resolved.conf.d"
+	// ResolveFile is the name of that drop-in.
+	ResolveFile = "80-hetzner.conf"
 )
 
-func writeConfigs(entries []NetworkConfigEntry) bool {
+func writeConfigs(entries []NetworkConfigEntry, globalSysctls []sysctlSetting) bool {
 	ok := true
 
 	if err := os.MkdirAll(ConfigDir, 0755); err != nil {
@@ -78,60 +113,253 @@ func writeConfigs(entries []NetworkConfigEntry) bool {
 
 	// Write new configs.
 	for _, entry := range entries {
+		if entry.Type == "nameserver" {
+			if !writeResolveConfig(entry.Address, entry.Search) {
+				ok = false
+			}
+			continue
+		}
 		if entry.Type != "physical" {
 			continue
 		}
 
-		// Interface names don't match, so match by MAC address.
-		config := "[Match]\n"
-		config += fmt.Sprintf("MACAddress=%s\n", entry.MACAddress)
+		if !writeEntryConfig(entry, globalSysctls) {
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// writeEntryConfig renders and writes the systemd-networkd .network file,
+// and any sysctl drop-in, for a single "physical" NetworkConfigEntry.
+func writeEntryConfig(entry NetworkConfigEntry, globalSysctls []sysctlSetting) bool {
+	ok := true
+
+	// Interface names don't match, so match by MAC address.
+	config := "[Match]\n"
+	config += fmt.Sprintf("MACAddress=%s\n", entry.MACAddress)
+	config += "\n"
+
+	mtu := 0
+	var routes []Route
+	for _, subnet := range entry.Subnets {
+		if subnet.MTU != 0 {
+			mtu = subnet.MTU
+		}
+		routes = append(routes, subnet.Routes...)
+	}
+	if mtu != 0 {
+		config += "[Link]\n"
+		config += fmt.Sprintf("MTUBytes=%d\n", mtu)
 		config += "\n"
+	}
 
-		config += "[Network]\n"
+	config += "[Network]\n"
 
-		// The metadata service uses an IPv4 link-local address. In practice, it
-		// works without this, but that's probably a quirk of the virtual interface.
-		// (As for IPv6 link-local addressing, that's typically always enabled.)
-		config += "LinkLocalAddressing=yes\n"
+	// The metadata service uses an IPv4 link-local address. In practice, it
+	// works without this, but that's probably a quirk of the virtual interface.
+	// (As for IPv6 link-local addressing, that's typically always enabled.)
+	config += "LinkLocalAddressing=yes\n"
 
-		wantDHCPv4 := false
-		wantDHCPv6 := false
-		for _, subnet := range entry.Subnets {
-			if subnet.Type == "dhcp" {
-				wantDHCPv4 = wantDHCPv4 || subnet.Ipv4
-				wantDHCPv6 = wantDHCPv6 || subnet.Ipv6
-			}
+	wantDHCPv4 := false
+	wantDHCPv6 := false
+	wantIPv6 := false
+	hints := ipv6Hints{}
+	for _, subnet := range entry.Subnets {
+		if subnet.Type == "dhcp" {
+			wantDHCPv4 = wantDHCPv4 || subnet.Ipv4
+			wantDHCPv6 = wantDHCPv6 || subnet.Ipv6
+		}
+		if subnet.Ipv6 {
+			wantIPv6 = true
+		}
+		if subnet.AcceptRA != nil {
+			hints.acceptRA = subnet.AcceptRA
+		}
+		if subnet.UseDNSFromRA != nil {
+			hints.useDNS = subnet.UseDNSFromRA
+		}
+		if subnet.UseDomainsFromRA != nil {
+			hints.useDomains = subnet.UseDomainsFromRA
+		}
+		// Only "static" subnets carry an Address/Gateway of their own;
+		// "dhcp" subnets are handled via the DHCP= toggle below instead.
+		if subnet.Type == "static" {
 			if subnet.Address != "" {
 				config += fmt.Sprintf("Address=%s\n", subnet.Address)
 			}
 			if subnet.Gateway != "" {
 				config += fmt.Sprintf("Gateway=%s\n", subnet.Gateway)
 			}
-			for _, ns := range subnet.DNSNameservers {
-				config += fmt.Sprintf("DNS=%s\n", ns)
-			}
 		}
-		if wantDHCPv4 {
-			if wantDHCPv6 {
-				config += "DHCP=yes\n"
-			} else {
-				config += "DHCP=ipv4\n"
-			}
-		} else if wantDHCPv6 {
-			config += "DHCP=ipv6\n"
+		for _, ns := range subnet.DNSNameservers {
+			config += fmt.Sprintf("DNS=%s\n", ns)
 		}
+	}
+	if wantDHCPv4 {
+		if wantDHCPv6 {
+			config += "DHCP=yes\n"
+		} else {
+			config += "DHCP=ipv4\n"
+		}
+	} else if wantDHCPv6 {
+		config += "DHCP=ipv6\n"
+	}
 
-		configPath := ConfigDir + "/" + ConfigPrefix + entry.Name + ConfigSuffix
-		if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
-			log.Printf("writing \"%s\": %v", configPath, err)
-			ok = false
+	sysctls, err := parseSysctls(entry.Sysctls)
+	if err != nil {
+		log.Printf("parsing sysctls for %q: %v", entry.Name, err)
+		ok = false
+	}
+	sysctls = append(append([]sysctlSetting{}, globalSysctls...), sysctls...)
+	dropinSysctls := applySysctls(sysctls, &hints)
+
+	// Router advertisements are how Hetzner delivers the IPv6 default
+	// route, so accept them by default once an IPv6 subnet is present.
+	if wantIPv6 && hints.acceptRA == nil {
+		hints.acceptRA = boolPtr(true)
+	}
+	if hints.acceptRA != nil {
+		config += fmt.Sprintf("IPv6AcceptRA=%s\n", boolStr(*hints.acceptRA))
+	}
+	if fw := ipForwardValue(hints.ipv4Forward, hints.ipv6Forward); fw != "" {
+		config += fmt.Sprintf("IPForward=%s\n", fw)
+	}
+	if hints.useDNS != nil || hints.useDomains != nil {
+		config += "\n[IPv6AcceptRA]\n"
+		if hints.useDNS != nil {
+			config += fmt.Sprintf("UseDNS=%s\n", boolStr(*hints.useDNS))
+		}
+		if hints.useDomains != nil {
+			config += fmt.Sprintf("UseDomains=%s\n", boolStr(*hints.useDomains))
 		}
 	}
 
+	for _, route := range routes {
+		config += "\n[Route]\n"
+		if route.Destination != "" {
+			config += fmt.Sprintf("Destination=%s\n", route.Destination)
+		}
+		if route.Gateway != "" {
+			config += fmt.Sprintf("Gateway=%s\n", route.Gateway)
+		}
+		if route.Metric != 0 {
+			config += fmt.Sprintf("Metric=%d\n", route.Metric)
+		}
+		if route.Table != "" {
+			config += fmt.Sprintf("Table=%s\n", route.Table)
+		}
+		if route.Scope != "" {
+			config += fmt.Sprintf("Scope=%s\n", route.Scope)
+		}
+	}
+
+	configPath := ConfigDir + "/" + ConfigPrefix + entry.Name + ConfigSuffix
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		log.Printf("writing \"%s\": %v", configPath, err)
+		ok = false
+	}
+
+	if !writeSysctlDropin(entry.Name, dropinSysctls) {
+		ok = false
+	}
+
 	return ok
 }
 
+// removeEntryConfig removes a previously written .network file and sysctl
+// drop-in for the named interface. Used by the --watch reconciler when an
+// interface disappears from the metadata between polls.
+func removeEntryConfig(name string) bool {
+	ok := true
+
+	configPath := ConfigDir + "/" + ConfigPrefix + name + ConfigSuffix
+	if err := os.Remove(configPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("removing \"%s\": %v", configPath, err)
+		ok = false
+	}
+
+	sysctlPath := SysctlDir + "/" + ConfigPrefix + name + ".conf"
+	if err := os.Remove(sysctlPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("removing \"%s\": %v", sysctlPath, err)
+		ok = false
+	}
+
+	return ok
+}
+
+// writeResolveConfig writes a top-level "nameserver" entry as a
+// systemd-resolved drop-in, rather than attaching DNS to a physical
+// interface.
+func writeResolveConfig(addresses, search []string) bool {
+	if len(addresses) == 0 && len(search) == 0 {
+		return true
+	}
+
+	config := "[Resolve]\n"
+	for _, addr := range addresses {
+		config += fmt.Sprintf("DNS=%s\n", addr)
+	}
+	if len(search) > 0 {
+		config += fmt.Sprintf("Domains=%s\n", strings.Join(search, " "))
+	}
+
+	if err := os.MkdirAll(ResolveDir, 0755); err != nil {
+		log.Printf("creating \"%s\": %v", ResolveDir, err)
+		return false
+	}
+
+	configPath := ResolveDir + "/" + ResolveFile
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		log.Printf("writing \"%s\": %v", configPath, err)
+		return false
+	}
+
+	return true
+}
+
+// removeResolveConfig removes a previously written resolved.conf.d drop-in.
+// Used by the --watch reconciler when the top-level "nameserver" entry
+// disappears from the metadata between polls.
+func removeResolveConfig() bool {
+	configPath := ResolveDir + "/" + ResolveFile
+	if err := os.Remove(configPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("removing \"%s\": %v", configPath, err)
+		return false
+	}
+	return true
+}
+
+// envOrDefault returns the value of the named environment variable, or def
+// if it is unset or empty.
+func envOrDefault(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		runCheck()
+	}
+
+	outputFormat := flag.String("output-format", envOrDefault("OUTPUT_FORMAT", "networkd"),
+		"output format for generated network config: \"networkd\" or \"netplan\"")
+	netplanPath := flag.String("netplan-path", envOrDefault("NETPLAN_PATH", NetplanDir+"/"+NetplanFile),
+		"path to write the Netplan config to, when -output-format=netplan")
+	sysctlConfigPath := flag.String("sysctl-config", envOrDefault("SYSCTL_CONFIG", ""),
+		"optional path to a file of short-form sysctl settings, one per line, applied to every interface")
+	watch := flag.Bool("watch", envOrDefault("WATCH", "") != "",
+		"stay resident, polling metadata and reconciling changes instead of exiting after the first write")
+	flag.Parse()
+
+	globalSysctls, err := readSysctlConfig(*sysctlConfigPath)
+	if err != nil {
+		log.Printf("reading \"%s\": %v", *sysctlConfigPath, err)
+	}
+
 	// Check if we have link on an ethernet interface.
 	links, err := netlink.LinkList()
 	if err != nil {
@@ -184,37 +412,73 @@ func main() {
 		}
 	}
 
+	// recheckLink re-adds the temporary link-local address between fetch
+	// retries, in case it (or the interface) got torn down prematurely
+	// while the metadata service was still settling.
+	recheckLink := func() {
+		if haveLink || firstEn == nil {
+			return
+		}
+		if addrs, err := netlink.AddrList(firstEn, netlink.FAMILY_V4); err == nil {
+			for _, addr := range addrs {
+				if addr.IPNet != nil && addr.IPNet.IP.Equal(llAddr.IPNet.IP) {
+					return
+				}
+			}
+		}
+		if err := netlink.LinkSetUp(firstEn); err != nil {
+			log.Printf("bringing up %s: %v", firstEnName, err)
+			return
+		}
+		if err := netlink.AddrAdd(firstEn, llAddr); err != nil {
+			log.Printf("adding link-local address to %s: %v", firstEnName, err)
+		}
+	}
+
 	// Fetch metadata.
 	ok := false
-	client := &http.Client{
-		// Should respond quick, so reasonably short timeout.
-		// Don't want to immobilize system startup because of an outage.
-		Timeout: 10 * time.Second,
-	}
-	var metadata Metadata
-	if resp, err := client.Get("http://169.254.169.254/hetzner/v1/metadata"); err != nil {
+	// appliedEntries is the watch baseline: it only reflects entries that
+	// were actually written to disk, so a failed initial write (disk full,
+	// permission error) still looks different from the next poll's result
+	// and gets retried, instead of being mistaken for "no change".
+	var appliedEntries []NetworkConfigEntry
+	metadata, err := fetchMetadata(context.Background(), recheckLink)
+	if err != nil {
 		log.Printf("fetching metadata: %v", err)
+	} else if metadata.NetworkConfig.Version != 1 {
+		log.Printf("fetching metadata: unknown network-config version %d", metadata.NetworkConfig.Version)
+	} else if err := metadata.Validate(); err != nil {
+		log.Printf("validating metadata, keeping previous generation: %v", err)
 	} else {
-		if resp.StatusCode != 200 {
-			log.Printf("fetching metadata: unexpected http status %d", resp.StatusCode)
-		} else if body, err := io.ReadAll(resp.Body); err != nil {
-			log.Printf("fetching metadata: read error: %v", err)
-		} else if err := yaml.Unmarshal(body, &metadata); err != nil {
-			log.Printf("fetching metadata: parse error: %v", err)
-		} else if metadata.NetworkConfig.Version != 1 {
-			log.Printf("fetching metadata: unknown network-config version %d", metadata.NetworkConfig.Version)
-		} else {
-			ok = writeConfigs(metadata.NetworkConfig.Config)
+		validEntries := metadata.NetworkConfig.Config
+		switch *outputFormat {
+		case "netplan":
+			ok = writeNetplanConfig(validEntries, *netplanPath, globalSysctls)
+		case "networkd":
+			ok = writeConfigs(validEntries, globalSysctls)
+		default:
+			log.Printf("unknown -output-format %q", *outputFormat)
+		}
+		if ok {
+			appliedEntries = validEntries
 		}
-		resp.Body.Close()
 	}
 
-	// Bring down the interface again.
-	if !haveLink && firstEn != nil {
-		netlink.AddrDel(firstEn, llAddr)
-		netlink.LinkSetDown(firstEn)
+	teardownLink := func() {
+		if !haveLink && firstEn != nil {
+			netlink.AddrDel(firstEn, llAddr)
+			netlink.LinkSetDown(firstEn)
+		}
+	}
+
+	if *watch {
+		runWatch(appliedEntries, *outputFormat, *netplanPath, globalSysctls, recheckLink, teardownLink)
+		return
 	}
 
+	// Bring down the interface again.
+	teardownLink()
+
 	if !ok {
 		os.Exit(1)
 	}
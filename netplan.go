@@ -0,0 +1,201 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// NetplanDir is the default location for a generated Netplan config.
+	NetplanDir = "/run/netplan"
+	// NetplanFile is the default name of the generated Netplan config.
+	NetplanFile = "80-hetzner.yaml"
+)
+
+type netplanConfig struct {
+	Network netplanNetwork `yaml:"network"`
+}
+
+type netplanNetwork struct {
+	Version   int                        `yaml:"version"`
+	Renderer  string                     `yaml:"renderer"`
+	Ethernets map[string]netplanEthernet `yaml:"ethernets,omitempty"`
+}
+
+type netplanEthernet struct {
+	Match       netplanMatch        `yaml:"match"`
+	Addresses   []string            `yaml:"addresses,omitempty"`
+	Gateway4    string              `yaml:"gateway4,omitempty"`
+	Gateway6    string              `yaml:"gateway6,omitempty"`
+	Nameservers *netplanNameservers `yaml:"nameservers,omitempty"`
+	DHCP4       bool                `yaml:"dhcp4,omitempty"`
+	DHCP6       bool                `yaml:"dhcp6,omitempty"`
+	MTU         int                 `yaml:"mtu,omitempty"`
+	Routes      []netplanRoute      `yaml:"routes,omitempty"`
+	AcceptRA    *bool               `yaml:"accept-ra,omitempty"`
+}
+
+type netplanMatch struct {
+	MACAddress string `yaml:"macaddress"`
+}
+
+type netplanNameservers struct {
+	Addresses []string `yaml:"addresses,omitempty"`
+	Search    []string `yaml:"search,omitempty"`
+}
+
+// netplanRoute is a single entry in an ethernet's routes: list, the Netplan
+// equivalent of a systemd-networkd [Route] section.
+type netplanRoute struct {
+	To     string `yaml:"to"`
+	Via    string `yaml:"via,omitempty"`
+	Metric int    `yaml:"metric,omitempty"`
+	Table  int    `yaml:"table,omitempty"`
+}
+
+// writeNetplanConfig renders entries as a Netplan YAML document at path,
+// for use on images where `netplan generate`/`netplan apply` is expected to
+// own the final systemd-networkd state instead of racing this generator for
+// files in ConfigDir. Routes, MTU, IPv6 router-advertisement acceptance and
+// top-level "nameserver" entries are all rendered using their native
+// Netplan equivalents; sysctl knobs that have no Netplan equivalent (e.g.
+// forwarding, rp_filter) still go to a /run/sysctl.d drop-in, same as the
+// networkd renderer.
+func writeNetplanConfig(entries []NetworkConfigEntry, path string, globalSysctls []sysctlSetting) bool {
+	ok := true
+	ethernets := make(map[string]netplanEthernet)
+
+	var globalNameservers, globalSearch []string
+	for _, entry := range entries {
+		if entry.Type == "nameserver" {
+			globalNameservers = append(globalNameservers, entry.Address...)
+			globalSearch = append(globalSearch, entry.Search...)
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.Type != "physical" {
+			continue
+		}
+
+		eth := netplanEthernet{
+			Match: netplanMatch{MACAddress: entry.MACAddress},
+		}
+
+		wantIPv6 := false
+		hints := ipv6Hints{}
+		var nameservers []string
+		for _, subnet := range entry.Subnets {
+			if subnet.Type == "dhcp" {
+				eth.DHCP4 = eth.DHCP4 || subnet.Ipv4
+				eth.DHCP6 = eth.DHCP6 || subnet.Ipv6
+			}
+			if subnet.Ipv6 {
+				wantIPv6 = true
+			}
+			if subnet.AcceptRA != nil {
+				hints.acceptRA = subnet.AcceptRA
+			}
+			if subnet.Type == "static" {
+				if subnet.Address != "" {
+					eth.Addresses = append(eth.Addresses, subnet.Address)
+				}
+				if subnet.Gateway != "" {
+					if ip := net.ParseIP(subnet.Gateway); ip != nil && ip.To4() != nil {
+						eth.Gateway4 = subnet.Gateway
+					} else {
+						eth.Gateway6 = subnet.Gateway
+					}
+				}
+			}
+			if subnet.MTU != 0 {
+				eth.MTU = subnet.MTU
+			}
+			nameservers = append(nameservers, subnet.DNSNameservers...)
+
+			for _, route := range subnet.Routes {
+				// A Route with no Destination is a gateway-only default
+				// route, same as in writeEntryConfig; Netplan has no
+				// equivalent of an omitted "to", so it needs the literal
+				// "default" instead of an empty string.
+				to := route.Destination
+				if to == "" {
+					to = "default"
+				}
+				nr := netplanRoute{To: to, Via: route.Gateway, Metric: route.Metric}
+				if route.Table != "" {
+					if table, err := strconv.Atoi(route.Table); err == nil {
+						nr.Table = table
+					} else {
+						log.Printf("netplan: route table %q for %q isn't numeric, omitting table=", route.Table, entry.Name)
+					}
+				}
+				eth.Routes = append(eth.Routes, nr)
+			}
+		}
+
+		sysctls, err := parseSysctls(entry.Sysctls)
+		if err != nil {
+			log.Printf("parsing sysctls for %q: %v", entry.Name, err)
+			ok = false
+		}
+		sysctls = append(append([]sysctlSetting{}, globalSysctls...), sysctls...)
+		dropinSysctls := applySysctls(sysctls, &hints)
+
+		if wantIPv6 && hints.acceptRA == nil {
+			hints.acceptRA = boolPtr(true)
+		}
+		eth.AcceptRA = hints.acceptRA
+
+		// Netplan has no IPForward= equivalent, so forwarding hints (from a
+		// sysctls entry) still go out as a raw sysctl drop-in, unlike
+		// accept-ra above.
+		if hints.ipv4Forward != nil {
+			dropinSysctls = append(dropinSysctls, sysctlSetting{Key: "ipv4.conf.forwarding", Value: forwardSysctlValue(*hints.ipv4Forward)})
+		}
+		if hints.ipv6Forward != nil {
+			dropinSysctls = append(dropinSysctls, sysctlSetting{Key: "ipv6.conf.forwarding", Value: forwardSysctlValue(*hints.ipv6Forward)})
+		}
+		if !writeSysctlDropin(entry.Name, dropinSysctls) {
+			ok = false
+		}
+
+		nameservers = append(nameservers, globalNameservers...)
+		search := append([]string{}, globalSearch...)
+		if len(nameservers) > 0 || len(search) > 0 {
+			eth.Nameservers = &netplanNameservers{Addresses: nameservers, Search: search}
+		}
+
+		ethernets[entry.Name] = eth
+	}
+
+	out, err := yaml.Marshal(netplanConfig{
+		Network: netplanNetwork{
+			Version:   2,
+			Renderer:  "networkd",
+			Ethernets: ethernets,
+		},
+	})
+	if err != nil {
+		log.Printf("marshaling netplan config: %v", err)
+		return false
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("creating \"%s\": %v", dir, err)
+		return false
+	}
+
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		log.Printf("writing \"%s\": %v", path, err)
+		return false
+	}
+
+	return ok
+}
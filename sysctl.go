@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SysctlDir is where per-interface sysctl drop-ins are written, one file
+// per interface so each can be added or removed independently as
+// interfaces come and go.
+const SysctlDir = "/run/sysctl.d"
+
+// sysctlSetting is a single short-form knob such as "ipv6.conf.accept_ra=2",
+// as carried in metadata or a local sysctl config file. The "conf." segment
+// is expanded to the target interface when written to a drop-in, e.g.
+// "net.ipv6.conf.eth0.accept_ra".
+type sysctlSetting struct {
+	Key   string
+	Value string
+}
+
+// ipv6Hints carries the subnet/sysctl-derived settings that become
+// [Network] IPv6AcceptRA=/IPForward= and [IPv6AcceptRA] UseDNS=/UseDomains=
+// directives in a generated .network file. A nil field means "let systemd-
+// networkd use its own default", except acceptRA, which the renderers set
+// to true for an interface with an IPv6 subnet.
+type ipv6Hints struct {
+	acceptRA    *bool
+	ipv4Forward *bool
+	ipv6Forward *bool
+	useDNS      *bool
+	useDomains  *bool
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+// forwardSysctlValue renders a forwarding hint back into the "1"/"0" form
+// sysctl.d drop-ins expect, for renderers (like Netplan) with no native
+// IPForward= directive to carry it instead.
+func forwardSysctlValue(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func boolStr(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// ipForwardValue renders the systemd-networkd IPForward= value implied by
+// the ipv4/ipv6 forwarding hints, or "" if neither was set.
+func ipForwardValue(ipv4, ipv6 *bool) string {
+	if ipv4 == nil && ipv6 == nil {
+		return ""
+	}
+	wantIPv4 := ipv4 != nil && *ipv4
+	wantIPv6 := ipv6 != nil && *ipv6
+	switch {
+	case wantIPv4 && wantIPv6:
+		return "yes"
+	case wantIPv4:
+		return "ipv4"
+	case wantIPv6:
+		return "ipv6"
+	default:
+		return "no"
+	}
+}
+
+// parseSysctls parses a list of "key=value" short-form sysctl settings,
+// ignoring blank lines and lines starting with "#".
+func parseSysctls(lines []string) ([]sysctlSetting, error) {
+	var settings []sysctlSetting
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid sysctl setting %q, want key=value", line)
+		}
+		settings = append(settings, sysctlSetting{
+			Key:   strings.TrimSpace(key),
+			Value: strings.TrimSpace(value),
+		})
+	}
+	return settings, nil
+}
+
+// readSysctlConfig reads short-form sysctl settings, one per line, from a
+// local config file. An empty path, or a path that doesn't exist, is not an
+// error: it simply yields no settings.
+func readSysctlConfig(path string) ([]sysctlSetting, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return parseSysctls(strings.Split(string(data), "\n"))
+}
+
+// applySysctls consumes the settings this generator knows how to translate
+// into native [Network] directives (accept_ra and forwarding), recording
+// them onto hints, and returns the rest for writeSysctlDropin to write
+// verbatim.
+func applySysctls(settings []sysctlSetting, hints *ipv6Hints) []sysctlSetting {
+	var rest []sysctlSetting
+	for _, s := range settings {
+		switch s.Key {
+		case "ipv6.conf.accept_ra":
+			hints.acceptRA = boolPtr(s.Value != "0")
+		case "ipv6.conf.forwarding":
+			hints.ipv6Forward = boolPtr(s.Value != "0")
+		case "ipv4.conf.forwarding":
+			hints.ipv4Forward = boolPtr(s.Value != "0")
+		default:
+			rest = append(rest, s)
+		}
+	}
+	return rest
+}
+
+// writeSysctlDropin writes the remaining, interface-specific sysctl
+// settings to a drop-in under SysctlDir, expanding the "conf." segment of
+// each short-form key to the given interface name.
+func writeSysctlDropin(ifaceName string, settings []sysctlSetting) bool {
+	if len(settings) == 0 {
+		return true
+	}
+
+	var config strings.Builder
+	for _, s := range settings {
+		key := strings.Replace(s.Key, "conf.", "conf."+ifaceName+".", 1)
+		fmt.Fprintf(&config, "net.%s = %s\n", key, s.Value)
+	}
+
+	if err := os.MkdirAll(SysctlDir, 0755); err != nil {
+		log.Printf("creating \"%s\": %v", SysctlDir, err)
+		return false
+	}
+
+	path := filepath.Join(SysctlDir, "80-hetzner-"+ifaceName+".conf")
+	if err := os.WriteFile(path, []byte(config.String()), 0644); err != nil {
+		log.Printf("writing \"%s\": %v", path, err)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Validate checks a parsed Metadata document for problems that would
+// otherwise cause writeConfigs to partially write a mixed, inconsistent
+// generation. It collects every problem it finds into a single joined
+// error rather than stopping at the first one, so the caller only has to
+// decide once whether to call writeConfigs or leave the previous
+// generation's files in place.
+func (m *Metadata) Validate() error {
+	var errs []error
+
+	seenMAC := make(map[string]string)
+
+	for i, entry := range m.NetworkConfig.Config {
+		label := fmt.Sprintf("entry %d", i)
+		if entry.Name != "" {
+			label = fmt.Sprintf("%s (%s)", label, entry.Name)
+		}
+
+		switch entry.Type {
+		case "physical":
+			if entry.MACAddress == "" {
+				errs = append(errs, fmt.Errorf("%s: missing mac_address", label))
+			} else if _, err := net.ParseMAC(entry.MACAddress); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid mac_address %q: %w", label, entry.MACAddress, err))
+			} else if other, dup := seenMAC[entry.MACAddress]; dup {
+				errs = append(errs, fmt.Errorf("%s: mac_address %q is also used by %s", label, entry.MACAddress, other))
+			} else {
+				seenMAC[entry.MACAddress] = label
+			}
+			errs = append(errs, validateSubnets(label, entry.Subnets)...)
+			if _, err := parseSysctls(entry.Sysctls); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", label, err))
+			}
+		case "nameserver":
+			for _, addr := range entry.Address {
+				if net.ParseIP(addr) == nil {
+					errs = append(errs, fmt.Errorf("%s: invalid nameserver address %q", label, addr))
+				}
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s: unknown type %q", label, entry.Type))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateSubnets checks a single entry's subnets, including that a
+// dhcp subnet doesn't also carry a conflicting static address, and that
+// every DNS nameserver's address family matches a subnet actually present
+// on the interface.
+func validateSubnets(label string, subnets []Subnet) []error {
+	var errs []error
+
+	wantDHCPv4, wantDHCPv6 := false, false
+	haveStaticV4, haveStaticV6 := false, false
+	haveSubnetV4, haveSubnetV6 := false, false
+	haveNSV4, haveNSV6 := false, false
+
+	for j, subnet := range subnets {
+		sublabel := fmt.Sprintf("%s subnet %d", label, j)
+
+		switch subnet.Type {
+		case "dhcp":
+			wantDHCPv4 = wantDHCPv4 || subnet.Ipv4
+			wantDHCPv6 = wantDHCPv6 || subnet.Ipv6
+		case "static":
+			if subnet.Address == "" {
+				errs = append(errs, fmt.Errorf("%s: static subnet missing address", sublabel))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s: unknown type %q", sublabel, subnet.Type))
+		}
+
+		if subnet.Ipv4 {
+			haveSubnetV4 = true
+		}
+		if subnet.Ipv6 {
+			haveSubnetV6 = true
+		}
+
+		if subnet.Address != "" {
+			ip, _, err := net.ParseCIDR(subnet.Address)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid address %q: %w", sublabel, subnet.Address, err))
+			} else if ip.To4() != nil {
+				haveStaticV4 = true
+			} else {
+				haveStaticV6 = true
+			}
+		}
+
+		if subnet.Gateway != "" && net.ParseIP(subnet.Gateway) == nil {
+			errs = append(errs, fmt.Errorf("%s: invalid gateway %q", sublabel, subnet.Gateway))
+		}
+
+		for _, ns := range subnet.DNSNameservers {
+			ip := net.ParseIP(ns)
+			if ip == nil {
+				errs = append(errs, fmt.Errorf("%s: invalid dns_nameserver %q", sublabel, ns))
+			} else if ip.To4() != nil {
+				haveNSV4 = true
+			} else {
+				haveNSV6 = true
+			}
+		}
+
+		for k, route := range subnet.Routes {
+			if route.Destination != "" {
+				if _, _, err := net.ParseCIDR(route.Destination); err != nil {
+					errs = append(errs, fmt.Errorf("%s route %d: invalid destination %q: %w", sublabel, k, route.Destination, err))
+				}
+			}
+			if route.Gateway != "" && net.ParseIP(route.Gateway) == nil {
+				errs = append(errs, fmt.Errorf("%s route %d: invalid gateway %q", sublabel, k, route.Gateway))
+			}
+		}
+	}
+
+	if wantDHCPv4 && haveStaticV4 {
+		errs = append(errs, fmt.Errorf("%s: static IPv4 address conflicts with dhcp", label))
+	}
+	if wantDHCPv6 && haveStaticV6 {
+		errs = append(errs, fmt.Errorf("%s: static IPv6 address conflicts with dhcp", label))
+	}
+	if haveNSV4 && !haveSubnetV4 {
+		errs = append(errs, fmt.Errorf("%s: has an IPv4 dns_nameserver but no IPv4 subnet", label))
+	}
+	if haveNSV6 && !haveSubnetV6 {
+		errs = append(errs, fmt.Errorf("%s: has an IPv6 dns_nameserver but no IPv6 subnet", label))
+	}
+
+	return errs
+}
+
+// runCheck implements the "check" subcommand: it parses a metadata document
+// from stdin and reports every validation problem found, for use in CI and
+// for debugging odd Hetzner responses. It exits the process directly.
+func runCheck() {
+	body, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading stdin: %v\n", err)
+		os.Exit(2)
+	}
+
+	var metadata Metadata
+	if err := yaml.Unmarshal(body, &metadata); err != nil {
+		fmt.Fprintf(os.Stderr, "parse error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := metadata.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
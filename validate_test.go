@@ -0,0 +1,141 @@
+package main
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       Metadata
+		wantErr bool
+	}{
+		{
+			name: "valid static and dhcp",
+			m: Metadata{NetworkConfig: NetworkConfig{Version: 1, Config: []NetworkConfigEntry{
+				{
+					Name:       "eth0",
+					Type:       "physical",
+					MACAddress: "96:00:00:00:00:01",
+					Subnets: []Subnet{
+						{Type: "static", Ipv4: true, Address: "10.0.0.2/24"},
+					},
+				},
+				{
+					Name:       "eth1",
+					Type:       "physical",
+					MACAddress: "96:00:00:00:00:02",
+					Subnets: []Subnet{
+						{Type: "dhcp", Ipv4: true, Ipv6: true},
+					},
+				},
+				{
+					Type:    "nameserver",
+					Address: []string{"185.12.64.1"},
+				},
+			}}},
+		},
+		{
+			name: "missing mac_address",
+			m: Metadata{NetworkConfig: NetworkConfig{Config: []NetworkConfigEntry{
+				{Type: "physical"},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "invalid mac_address",
+			m: Metadata{NetworkConfig: NetworkConfig{Config: []NetworkConfigEntry{
+				{Type: "physical", MACAddress: "not-a-mac"},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "duplicate mac_address",
+			m: Metadata{NetworkConfig: NetworkConfig{Config: []NetworkConfigEntry{
+				{Name: "eth0", Type: "physical", MACAddress: "96:00:00:00:00:01"},
+				{Name: "eth1", Type: "physical", MACAddress: "96:00:00:00:00:01"},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "unknown entry type",
+			m: Metadata{NetworkConfig: NetworkConfig{Config: []NetworkConfigEntry{
+				{Type: "bogus"},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "invalid nameserver address",
+			m: Metadata{NetworkConfig: NetworkConfig{Config: []NetworkConfigEntry{
+				{Type: "nameserver", Address: []string{"not-an-ip"}},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "static subnet missing address",
+			m: Metadata{NetworkConfig: NetworkConfig{Config: []NetworkConfigEntry{
+				{
+					Name: "eth0", Type: "physical", MACAddress: "96:00:00:00:00:01",
+					Subnets: []Subnet{{Type: "static"}},
+				},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "dhcp conflicts with static address",
+			m: Metadata{NetworkConfig: NetworkConfig{Config: []NetworkConfigEntry{
+				{
+					Name: "eth0", Type: "physical", MACAddress: "96:00:00:00:00:01",
+					Subnets: []Subnet{
+						{Type: "dhcp", Ipv4: true},
+						{Type: "static", Ipv4: true, Address: "10.0.0.2/24"},
+					},
+				},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "dns_nameserver without matching subnet family",
+			m: Metadata{NetworkConfig: NetworkConfig{Config: []NetworkConfigEntry{
+				{
+					Name: "eth0", Type: "physical", MACAddress: "96:00:00:00:00:01",
+					Subnets: []Subnet{
+						{Type: "static", Ipv4: true, Address: "10.0.0.2/24", DNSNameservers: []string{"fe80::1"}},
+					},
+				},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "invalid route destination",
+			m: Metadata{NetworkConfig: NetworkConfig{Config: []NetworkConfigEntry{
+				{
+					Name: "eth0", Type: "physical", MACAddress: "96:00:00:00:00:01",
+					Subnets: []Subnet{
+						{Type: "static", Ipv4: true, Address: "10.0.0.2/24", Routes: []Route{
+							{Destination: "not-a-cidr"},
+						}},
+					},
+				},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "invalid sysctl setting",
+			m: Metadata{NetworkConfig: NetworkConfig{Config: []NetworkConfigEntry{
+				{
+					Name: "eth0", Type: "physical", MACAddress: "96:00:00:00:00:01",
+					Sysctls: []string{"not-key-value"},
+				},
+			}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.m.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+const (
+	// WatchIntervalEnv overrides the default --watch poll interval.
+	WatchIntervalEnv = "WATCH_INTERVAL"
+	// WatchInterval is the default interval between polls in --watch mode.
+	WatchInterval = 5 * time.Minute
+)
+
+// resolveWatchInterval returns the --watch poll interval, from
+// WatchIntervalEnv if set, otherwise WatchInterval.
+func resolveWatchInterval() time.Duration {
+	if v := envOrDefault(WatchIntervalEnv, ""); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		log.Printf("invalid %s %q, using default", WatchIntervalEnv, v)
+	}
+	return WatchInterval
+}
+
+// entryKey returns the key used to match a NetworkConfigEntry across polls:
+// physical interfaces are matched by name, and the (at most one) top-level
+// nameserver entry by its type.
+func entryKey(entry NetworkConfigEntry) string {
+	if entry.Type == "nameserver" {
+		return "nameserver"
+	}
+	return entry.Name
+}
+
+// indexEntries keys the interfaces and nameserver entry this generator
+// cares about, for diffing between polls.
+func indexEntries(entries []NetworkConfigEntry) map[string]NetworkConfigEntry {
+	m := make(map[string]NetworkConfigEntry, len(entries))
+	for _, entry := range entries {
+		if entry.Type != "physical" && entry.Type != "nameserver" {
+			continue
+		}
+		m[entryKey(entry)] = entry
+	}
+	return m
+}
+
+// reconcile diffs prev against next and rewrites only the interfaces whose
+// config actually changed. It returns the set of entries that are actually
+// on disk afterwards (applied): an entry whose write or removal failed
+// keeps its prior applied state, so the next poll sees it as still
+// different from next and retries it, instead of being silently skipped.
+func reconcile(prev, next []NetworkConfigEntry, globalSysctls []sysctlSetting) (applied []NetworkConfigEntry, ok, changed bool) {
+	ok = true
+	prevByKey := indexEntries(prev)
+	nextByKey := indexEntries(next)
+
+	appliedByKey := make(map[string]NetworkConfigEntry, len(prevByKey))
+	for k, v := range prevByKey {
+		appliedByKey[k] = v
+	}
+
+	for key, entry := range nextByKey {
+		old, existed := prevByKey[key]
+		if existed && reflect.DeepEqual(old, entry) {
+			continue
+		}
+		changed = true
+
+		var wrote bool
+		if entry.Type == "nameserver" {
+			wrote = writeResolveConfig(entry.Address, entry.Search)
+		} else {
+			wrote = writeEntryConfig(entry, globalSysctls)
+		}
+		if wrote {
+			appliedByKey[key] = entry
+		} else {
+			ok = false
+			if !existed {
+				// Never successfully written; nothing is applied for this
+				// key yet, so leave it out entirely.
+				delete(appliedByKey, key)
+			}
+		}
+	}
+
+	for key, old := range prevByKey {
+		if _, stillExists := nextByKey[key]; stillExists {
+			continue
+		}
+		changed = true
+
+		var removed bool
+		switch old.Type {
+		case "nameserver":
+			removed = removeResolveConfig()
+		case "physical":
+			removed = removeEntryConfig(old.Name)
+		default:
+			removed = true
+		}
+		if removed {
+			delete(appliedByKey, key)
+		} else {
+			ok = false
+		}
+	}
+
+	for _, entry := range appliedByKey {
+		applied = append(applied, entry)
+	}
+	return applied, ok, changed
+}
+
+// reloadNetworkd asks systemd-networkd to pick up the rewritten .network
+// files, equivalent to running `networkctl reload`.
+func reloadNetworkd() {
+	if out, err := exec.Command("networkctl", "reload").CombinedOutput(); err != nil {
+		log.Printf("networkctl reload: %v: %s", err, out)
+	}
+}
+
+// runWatch stays resident after the initial write, polling the metadata
+// service at resolveWatchInterval (and on SIGHUP). With networkd output it
+// reconciles only the interfaces that changed since the last applied state;
+// with netplan output it rewrites the whole Netplan file when anything
+// changed. On SIGINT/SIGTERM it calls cleanup and exits.
+func runWatch(last []NetworkConfigEntry, outputFormat, netplanPath string, globalSysctls []sysctlSetting, recheckLink func(), cleanup func()) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	sigterm := make(chan os.Signal, 1)
+	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigterm)
+
+	ticker := time.NewTicker(resolveWatchInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-sighup:
+			log.Printf("received SIGHUP, polling metadata")
+		case sig := <-sigterm:
+			log.Printf("received %s, shutting down", sig)
+			cleanup()
+			os.Exit(0)
+		}
+
+		metadata, err := fetchMetadata(context.Background(), recheckLink)
+		if err != nil {
+			log.Printf("fetching metadata: %v", err)
+			continue
+		}
+		if metadata.NetworkConfig.Version != 1 {
+			log.Printf("fetching metadata: unknown network-config version %d", metadata.NetworkConfig.Version)
+			continue
+		}
+		if err := metadata.Validate(); err != nil {
+			log.Printf("validating metadata, keeping previous generation: %v", err)
+			continue
+		}
+
+		next := metadata.NetworkConfig.Config
+		switch outputFormat {
+		case "netplan":
+			if !reflect.DeepEqual(last, next) {
+				if writeNetplanConfig(next, netplanPath, globalSysctls) {
+					last = next
+				}
+			}
+		case "networkd":
+			applied, ok, changed := reconcile(last, next, globalSysctls)
+			if changed && ok {
+				reloadNetworkd()
+			}
+			last = applied
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestEntryKey(t *testing.T) {
+	if got := entryKey(NetworkConfigEntry{Type: "nameserver"}); got != "nameserver" {
+		t.Errorf("entryKey(nameserver) = %q, want %q", got, "nameserver")
+	}
+	if got := entryKey(NetworkConfigEntry{Type: "physical", Name: "eth0"}); got != "eth0" {
+		t.Errorf("entryKey(physical) = %q, want %q", got, "eth0")
+	}
+}
+
+func TestIndexEntries(t *testing.T) {
+	entries := []NetworkConfigEntry{
+		{Type: "physical", Name: "eth0"},
+		{Type: "physical", Name: "eth1"},
+		{Type: "nameserver", Address: []string{"185.12.64.1"}},
+		{Type: "unrelated"},
+	}
+	got := indexEntries(entries)
+	var keys []string
+	for k := range got {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	want := []string{"eth0", "eth1", "nameserver"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("indexEntries keys = %v, want %v", keys, want)
+	}
+}
+
+// TestReconcileNoChange covers the bug from the review: reconcile must not
+// report a change (and runWatch must not advance its "last applied" state)
+// when nothing in next actually differs from prev.
+func TestReconcileNoChange(t *testing.T) {
+	t.Cleanup(func() { os.Remove(ResolveDir + "/" + ResolveFile) })
+
+	entries := []NetworkConfigEntry{
+		{Type: "nameserver", Address: []string{"185.12.64.1"}},
+	}
+	applied, ok, changed := reconcile(entries, entries, nil)
+	if !ok {
+		t.Fatalf("reconcile() ok = false, want true")
+	}
+	if changed {
+		t.Errorf("reconcile() changed = true, want false for identical prev/next")
+	}
+	if !reflect.DeepEqual(indexEntries(applied), indexEntries(entries)) {
+		t.Errorf("reconcile() applied = %v, want %v", applied, entries)
+	}
+}
+
+// TestReconcileAppliesWrittenState checks that when a changed entry is
+// written successfully, reconcile reports the change and applied holds the
+// new entry rather than the previous one.
+func TestReconcileAppliesWrittenState(t *testing.T) {
+	t.Cleanup(func() { os.Remove(ResolveDir + "/" + ResolveFile) })
+
+	prev := []NetworkConfigEntry{
+		{Type: "nameserver", Address: []string{"185.12.64.1"}},
+	}
+	next := []NetworkConfigEntry{
+		{Type: "nameserver", Address: []string{"185.12.64.2"}},
+	}
+
+	applied, ok, changed := reconcile(prev, next, nil)
+	if !ok {
+		t.Fatalf("reconcile() ok = false, want true")
+	}
+	if !changed {
+		t.Fatalf("reconcile() changed = false, want true")
+	}
+	if !reflect.DeepEqual(indexEntries(applied), indexEntries(next)) {
+		t.Errorf("reconcile() applied = %v, want %v", applied, next)
+	}
+}
+
+// TestReconcileRemovesNameserver is the regression test for the stale
+// resolved.conf.d drop-in: a disappearing top-level "nameserver" entry must
+// be removed from disk and dropped from applied, not merely flagged changed.
+func TestReconcileRemovesNameserver(t *testing.T) {
+	configPath := ResolveDir + "/" + ResolveFile
+	t.Cleanup(func() { os.Remove(configPath) })
+
+	prev := []NetworkConfigEntry{
+		{Type: "nameserver", Address: []string{"185.12.64.1"}},
+	}
+	if !writeResolveConfig(prev[0].Address, prev[0].Search) {
+		t.Fatalf("writeResolveConfig() = false, want true")
+	}
+
+	applied, ok, changed := reconcile(prev, nil, nil)
+	if !ok {
+		t.Fatalf("reconcile() ok = false, want true")
+	}
+	if !changed {
+		t.Fatalf("reconcile() changed = false, want true")
+	}
+	if len(applied) != 0 {
+		t.Errorf("reconcile() applied = %v, want empty", applied)
+	}
+	if _, err := os.Stat(configPath); !os.IsNotExist(err) {
+		t.Errorf("resolve config still exists after removal: %v", err)
+	}
+}